@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Configuration
+	NumShards         = 32                       // Number of shards for the map
+	MaxKeySize        = 256                      // Maximum key length
+	MaxValueSize      = 256                      // Maximum value length
+	MemoryThreshold   = 0.7                      // Memory threshold (70%)
+	CleanupInterval   = 5 * time.Second          // Interval to check memory usage
+	MaxMemoryBytes    = 1.5 * 1024 * 1024 * 1024 // 1.5GB max memory (leaving headroom)
+	EvictionBatchSize = 100                      // Number of items to evict in one batch
+)
+
+const (
+	// ReapInterval is how often RunTTLReaper sweeps each shard for expired
+	// entries, independent of (and typically more frequent than) the
+	// memory-pressure eviction driven by RunMemoryMonitor.
+	ReapInterval = 1 * time.Second
+)
+
+// ShardedCache is our main cache structure
+type ShardedCache struct {
+	shards    [NumShards]*Shard
+	totalSize int64
+	sizeLock  sync.RWMutex
+
+	disk *DiskTier // nil unless Config.DiskDir was set
+
+	memHits, diskHits, misses      int64 // atomic
+	evictions, deletes, collisions int64 // atomic
+}
+
+// Initialize a new sharded cache using the default Config (PolicySIEVE,
+// no TTL, no disk tier).
+func NewShardedCache() *ShardedCache {
+	cache, _ := NewShardedCacheWithConfig(defaultConfig())
+	return cache
+}
+
+// NewShardedCacheWithConfig initializes a cache with an explicit Config,
+// e.g. to select PolicyLRU, set a LifeWindow, or enable the disk tier via
+// DiskDir. It only returns an error if the disk tier fails to initialize.
+func NewShardedCacheWithConfig(cfg Config) (*ShardedCache, error) {
+	cache := &ShardedCache{}
+
+	if cfg.DiskDir != "" {
+		disk, err := NewDiskTier(cfg.DiskDir, cfg.MaxDiskBytes, cfg.DefaultTTL)
+		if err != nil {
+			return nil, err
+		}
+		cache.disk = disk
+	}
+
+	// Every shard gets this onEvict, not just when a disk tier or a user
+	// callback is configured, since it's also how the cache's own
+	// eviction/delete counters get updated.
+	onEvict := func(hash uint64, key, value string, ttl time.Duration, timestamp time.Time, reason EvictReason) {
+		switch reason {
+		case ReasonMemoryPressure, ReasonExpired:
+			atomic.AddInt64(&cache.evictions, 1)
+		case ReasonManualDelete:
+			atomic.AddInt64(&cache.deletes, 1)
+		}
+		if cache.disk != nil {
+			cache.disk.Spill(hash, key, value, ttl, timestamp, reason)
+		}
+		if cfg.OnEvict != nil {
+			cfg.OnEvict(key, value, reason)
+		}
+	}
+
+	for i := 0; i < NumShards; i++ {
+		shard := newShard(cfg)
+		shard.onEvict = onEvict
+		shard.collisions = &cache.collisions
+		cache.shards[i] = shard
+	}
+	return cache, nil
+}
+
+// hashKey computes the 64-bit FNV-1a hash of key used to pick a shard and
+// to address entries inside it.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// getShard picks a shard from the high bits of a key's hash, leaving the
+// rest of the hash free to serve as the shard's own map key.
+func (c *ShardedCache) getShard(hash uint64) *Shard {
+	return c.shards[(hash>>56)%NumShards]
+}
+
+// Add or update a key-value pair. The entry expires according to the
+// cache's Config.DefaultTTL, if any; use PutWithTTL to override that
+// per-key.
+func (c *ShardedCache) Put(key, value string) error {
+	return c.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL is like Put but expires the entry after ttl regardless of the
+// cache's DefaultTTL. A zero ttl falls back to DefaultTTL.
+func (c *ShardedCache) PutWithTTL(key, value string, ttl time.Duration) error {
+	if len(key) > MaxKeySize || len(value) > MaxValueSize {
+		return fmt.Errorf("key or value exceeds maximum size")
+	}
+
+	hash := hashKey(key)
+	shard := c.getShard(hash)
+
+	shard.lock.Lock()
+	written := shard.put(hash, key, value, ttl)
+	shard.lock.Unlock()
+
+	c.sizeLock.Lock()
+	c.totalSize += written
+	c.sizeLock.Unlock()
+
+	return nil
+}
+
+// Get a value by key. Unlike the original list-based cache, this never
+// upgrades an RLock to a Lock mid-lookup: recency tracking for PolicySIEVE
+// is an atomic bit on the entry (see Shard.visited), not a list move, so
+// the read lock held for the whole call is never released and reacquired
+// against state another goroutine could have freed out from under it.
+func (c *ShardedCache) Get(key string) (string, bool) {
+	hash := hashKey(key)
+	shard := c.getShard(hash)
+
+	shard.lock.RLock()
+	value, found := shard.get(hash, key)
+	shard.lock.RUnlock()
+
+	if found {
+		atomic.AddInt64(&c.memHits, 1)
+		return value, true
+	}
+
+	if c.disk != nil {
+		if value, found := c.disk.Get(hash, key); found {
+			atomic.AddInt64(&c.diskHits, 1)
+			c.Put(key, value) // promote back into memory
+			c.disk.Invalidate(hash)
+			return value, true
+		}
+	}
+
+	atomic.AddInt64(&c.misses, 1)
+	return "", false
+}
+
+// Delete removes key, if present, reporting whether it was found. OnEvict
+// fires with ReasonManualDelete.
+func (c *ShardedCache) Delete(key string) bool {
+	hash := hashKey(key)
+	shard := c.getShard(hash)
+
+	shard.lock.Lock()
+	freed, found := shard.delete(hash, key)
+	shard.lock.Unlock()
+
+	if !found {
+		return false
+	}
+
+	c.sizeLock.Lock()
+	c.totalSize -= freed
+	c.sizeLock.Unlock()
+	return true
+}
+
+// Len returns the total number of live entries across all shards.
+func (c *ShardedCache) Len() int {
+	var n int
+	for _, shard := range c.shards {
+		shard.lock.RLock()
+		n += len(shard.hashmap)
+		shard.lock.RUnlock()
+	}
+	return n
+}
+
+// Size returns the total number of live bytes held in memory across all
+// shards (not counting the disk tier).
+func (c *ShardedCache) Size() int64 {
+	c.sizeLock.RLock()
+	defer c.sizeLock.RUnlock()
+	return c.totalSize
+}
+
+// EvictBatch evicts multiple items at once
+func (c *ShardedCache) EvictBatch(count int) int64 {
+	var totalFreed int64 = 0
+
+	// Distribute evictions across shards
+	perShard := count / NumShards
+	if perShard < 1 {
+		perShard = 1
+	}
+
+	for i := 0; i < NumShards; i++ {
+		shard := c.shards[i]
+		shard.lock.Lock()
+
+		var shardFreed int64 = 0
+		for j := 0; j < perShard; j++ {
+			freed := shard.popOldest()
+			if freed == 0 {
+				break
+			}
+			shardFreed += freed
+		}
+
+		shard.lock.Unlock()
+
+		c.sizeLock.Lock()
+		c.totalSize -= shardFreed
+		totalFreed += shardFreed
+		c.sizeLock.Unlock()
+	}
+
+	return totalFreed
+}
+
+// CheckMemory checks and manages memory usage
+func (c *ShardedCache) CheckMemory() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	memUsage := float64(m.Alloc) / float64(MaxMemoryBytes)
+
+	if memUsage >= MemoryThreshold {
+		log.Printf("Memory usage at %.2f%%, evicting cache entries", memUsage*100)
+		// Aggressive eviction under high memory pressure
+		c.EvictBatch(EvictionBatchSize)
+	}
+}
+
+// RunMemoryMonitor starts a goroutine to monitor memory
+func (c *ShardedCache) RunMemoryMonitor() {
+	ticker := time.NewTicker(CleanupInterval)
+	go func() {
+		for range ticker.C {
+			c.CheckMemory()
+		}
+	}()
+}
+
+// RunTTLReaper starts a goroutine that periodically sweeps every shard for
+// expired entries, so keys with a TTL are reclaimed (and OnEvict fires with
+// ReasonExpired) even under no memory pressure and no further reads.
+func (c *ShardedCache) RunTTLReaper() {
+	ticker := time.NewTicker(ReapInterval)
+	go func() {
+		for range ticker.C {
+			var freed int64
+			for _, shard := range c.shards {
+				shard.lock.Lock()
+				freed += shard.reapExpired()
+				shard.lock.Unlock()
+			}
+			if freed > 0 {
+				c.sizeLock.Lock()
+				c.totalSize -= freed
+				c.sizeLock.Unlock()
+			}
+		}
+	}()
+}
+
+// CacheStats reports hit/miss counters alongside the disk tier's state, if
+// one is configured.
+type CacheStats struct {
+	MemoryHits int64
+	DiskHits   int64
+	Misses     int64
+	Evictions  int64
+	Deletes    int64
+	Collisions int64
+	Len        int
+	Size       int64
+	Disk       *DiskStats `json:"Disk,omitempty"`
+}
+
+// Stats returns a snapshot of the cache's hit/miss/eviction/collision
+// counters.
+func (c *ShardedCache) Stats() CacheStats {
+	stats := CacheStats{
+		MemoryHits: atomic.LoadInt64(&c.memHits),
+		DiskHits:   atomic.LoadInt64(&c.diskHits),
+		Misses:     atomic.LoadInt64(&c.misses),
+		Evictions:  atomic.LoadInt64(&c.evictions),
+		Deletes:    atomic.LoadInt64(&c.deletes),
+		Collisions: atomic.LoadInt64(&c.collisions),
+		Len:        c.Len(),
+		Size:       c.Size(),
+	}
+	if c.disk != nil {
+		diskStats := c.disk.Stats()
+		stats.Disk = &diskStats
+	}
+	return stats
+}
+
+// Flush blocks until every entry already handed to the disk tier has been
+// written out.
+func (c *ShardedCache) Flush() {
+	if c.disk != nil {
+		c.disk.Flush()
+	}
+}
+
+// Evacuate drains every shard's memory contents into the disk tier, for use
+// before a graceful shutdown. It is a no-op if no disk tier is configured.
+func (c *ShardedCache) Evacuate() {
+	if c.disk == nil {
+		return
+	}
+	for _, shard := range c.shards {
+		shard.lock.Lock()
+		for shard.used > 0 {
+			if shard.popOldest() == 0 {
+				break
+			}
+		}
+		shard.lock.Unlock()
+	}
+	c.disk.Flush()
+}
@@ -0,0 +1,62 @@
+package main
+
+import "time"
+
+// Policy selects the eviction algorithm each shard uses once it runs out
+// of room.
+type Policy int
+
+const (
+	// PolicySIEVE gives a recently-read entry one extra lap before
+	// eviction, at FIFO cost. See popOldestSIEVE. It is the zero value so
+	// a zero Config already gets it.
+	PolicySIEVE Policy = iota
+	// PolicyLRU evicts in strict arrival order. It exists mostly for
+	// continuity with the cache's original behavior; see popOldestFIFO.
+	PolicyLRU
+)
+
+// EvictReason explains why OnEvict fired for a given key.
+type EvictReason int
+
+const (
+	// ReasonExpired means the entry's TTL elapsed before it was evicted.
+	ReasonExpired EvictReason = iota
+	// ReasonMemoryPressure means the entry was evicted to make room.
+	ReasonMemoryPressure
+	// ReasonReplaced means a Put overwrote the entry with a new value.
+	ReasonReplaced
+	// ReasonManualDelete means Delete removed the entry.
+	ReasonManualDelete
+)
+
+// Config configures a ShardedCache at construction time.
+type Config struct {
+	// Policy is the per-shard eviction algorithm. The zero Config uses
+	// PolicySIEVE, since it's the zero value of Policy; pass PolicyLRU
+	// explicitly to opt out.
+	Policy Policy
+	// MaxShardBytes bounds how large a single shard's ring buffer may grow,
+	// in bytes. Zero uses the package default (MaxMemoryBytes / NumShards).
+	// Lower it to make eviction kick in on a small working set, e.g. in
+	// tests or benchmarks that need to force it.
+	MaxShardBytes int
+	// DefaultTTL, if non-zero, expires entries older than this duration
+	// unless they were written with their own TTL via PutWithTTL.
+	DefaultTTL time.Duration
+	// OnEvict, if set, is called whenever an entry leaves the cache,
+	// whether from memory, the disk tier, TTL expiry, a Put that
+	// replaced it, or a manual Delete.
+	OnEvict func(key, value string, reason EvictReason)
+
+	// DiskDir, if non-empty, enables a second-tier disk spill cache for
+	// entries evicted from memory, rooted at this directory.
+	DiskDir string
+	// MaxDiskBytes bounds the disk tier's total indexed size. Ignored if
+	// DiskDir is empty.
+	MaxDiskBytes int64
+}
+
+func defaultConfig() Config {
+	return Config{Policy: PolicySIEVE}
+}
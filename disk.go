@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// diskShardFileBytes bounds how large a single shard file grows
+	// before the writer rotates to a new one.
+	diskShardFileBytes = 1 << 30 // 1 GiB
+
+	diskWriteQueueSize = 4096
+	diskWorkerCount    = 4
+)
+
+// diskLocation is where one spilled entry lives on disk.
+type diskLocation struct {
+	fileIdx int
+	offset  int64
+	length  int64
+}
+
+// diskShard is the on-disk counterpart of an in-memory Shard: entries are
+// appended to the current write file and indexed by key hash, with a
+// CLOCK-style second-chance queue bounding how many bytes stay indexed.
+type diskShard struct {
+	mu sync.Mutex
+
+	dir      string
+	shardIdx int
+
+	locations map[uint64]diskLocation
+
+	curFile *os.File
+	curIdx  int
+	curOff  int64
+
+	order      []uint64 // FIFO order of live key hashes, oldest first
+	referenced map[uint64]bool
+
+	// readFiles caches read-only handles to block files rotated past by
+	// curFile, keyed by fileIdx, so Get can read entries that live in an
+	// older file without reopening it on every lookup.
+	readFiles map[int]*os.File
+
+	liveBytes int64
+	maxBytes  int64
+}
+
+// DiskStats reports the spill tier's current counters.
+type DiskStats struct {
+	WriteQueueDepth int
+	BytesUsed       int64
+}
+
+// DiskTier is the second-tier persistent cache that catches entries evicted
+// from memory instead of discarding them outright.
+type DiskTier struct {
+	dir    string
+	shards [NumShards]*diskShard
+
+	jobs chan diskWriteJob
+	wg   sync.WaitGroup
+
+	queued int64 // atomic: jobs enqueued but not yet written
+
+	// defaultTTL mirrors Config.DefaultTTL, so Get can resolve a spilled
+	// frame's per-entry ttl of 0 the same way Shard.get does.
+	defaultTTL time.Duration
+}
+
+type diskWriteJob struct {
+	hash      uint64
+	key       string
+	value     string
+	ttl       time.Duration
+	timestamp time.Time
+}
+
+// NewDiskTier creates (or reopens) a disk spill tier rooted at dir, with
+// maxBytes shared evenly across shards. defaultTTL mirrors Config.DefaultTTL
+// and is used to resolve a spilled entry's expiry the same way the shard it
+// came from would have.
+func NewDiskTier(dir string, maxBytes int64, defaultTTL time.Duration) (*DiskTier, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("disk tier: %w", err)
+	}
+
+	d := &DiskTier{
+		dir:        dir,
+		jobs:       make(chan diskWriteJob, diskWriteQueueSize),
+		defaultTTL: defaultTTL,
+	}
+	for i := range d.shards {
+		ds := &diskShard{
+			dir:        dir,
+			shardIdx:   i,
+			locations:  make(map[uint64]diskLocation),
+			referenced: make(map[uint64]bool),
+			maxBytes:   maxBytes / NumShards,
+		}
+		if err := ds.openFile(0); err != nil {
+			return nil, err
+		}
+		d.shards[i] = ds
+	}
+
+	for i := 0; i < diskWorkerCount; i++ {
+		d.wg.Add(1)
+		go d.writeWorker()
+	}
+
+	return d, nil
+}
+
+func (ds *diskShard) openFile(fileIdx int) error {
+	f, err := os.OpenFile(
+		filepath.Join(ds.dir, fmt.Sprintf("shard-%02d-%04d.blk", ds.shardIdx, fileIdx)),
+		os.O_RDWR|os.O_CREATE, 0o644,
+	)
+	if err != nil {
+		return fmt.Errorf("disk tier: open shard file: %w", err)
+	}
+	if ds.curFile != nil {
+		ds.curFile.Close()
+	}
+	ds.curFile = f
+	ds.curIdx = fileIdx
+	ds.curOff = 0
+	return nil
+}
+
+func (d *DiskTier) shardFor(hash uint64) *diskShard {
+	return d.shards[(hash>>56)%NumShards]
+}
+
+// fileFor returns a handle to read fileIdx, reusing curFile when it's the
+// current write file and otherwise opening (and caching) it read-only.
+// Called with shard.mu held.
+func (shard *diskShard) fileFor(fileIdx int) (*os.File, error) {
+	if fileIdx == shard.curIdx {
+		return shard.curFile, nil
+	}
+	if f, ok := shard.readFiles[fileIdx]; ok {
+		return f, nil
+	}
+	f, err := os.Open(filepath.Join(shard.dir, fmt.Sprintf("shard-%02d-%04d.blk", shard.shardIdx, fileIdx)))
+	if err != nil {
+		return nil, fmt.Errorf("disk tier: open shard file for read: %w", err)
+	}
+	if shard.readFiles == nil {
+		shard.readFiles = make(map[int]*os.File)
+	}
+	shard.readFiles[fileIdx] = f
+	return f, nil
+}
+
+// Spill enqueues key/value for asynchronous persistence, but only when
+// reason is ReasonMemoryPressure: expired, replaced, and manually deleted
+// entries are stale or superseded and have no business surviving onto the
+// disk tier. ttl and timestamp are the entry's original values from memory,
+// carried through so the spilled frame keeps expiring on its original
+// schedule instead of getting a fresh lease on life. It never blocks the
+// evicting caller on disk I/O; if the queue is full the entry is dropped,
+// same as it would have been without a disk tier.
+func (d *DiskTier) Spill(hash uint64, key, value string, ttl time.Duration, timestamp time.Time, reason EvictReason) {
+	if reason != ReasonMemoryPressure {
+		return
+	}
+	select {
+	case d.jobs <- diskWriteJob{hash: hash, key: key, value: value, ttl: ttl, timestamp: timestamp}:
+		atomic.AddInt64(&d.queued, 1)
+	default:
+	}
+}
+
+func (d *DiskTier) writeWorker() {
+	defer d.wg.Done()
+	for job := range d.jobs {
+		d.write(job)
+		atomic.AddInt64(&d.queued, -1)
+	}
+}
+
+func (d *DiskTier) write(job diskWriteJob) {
+	shard := d.shardFor(job.hash)
+	frame := encodeFrameAt(job.timestamp, job.key, job.value, job.ttl)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if shard.curOff+int64(len(frame)) > diskShardFileBytes {
+		if err := shard.openFile(shard.curIdx + 1); err != nil {
+			return
+		}
+	}
+
+	if _, err := shard.curFile.WriteAt(frame, shard.curOff); err != nil {
+		return
+	}
+
+	loc := diskLocation{fileIdx: shard.curIdx, offset: shard.curOff, length: int64(len(frame))}
+	shard.locations[job.hash] = loc
+	shard.order = append(shard.order, job.hash)
+	shard.referenced[job.hash] = false
+	shard.liveBytes += loc.length
+	shard.curOff += int64(len(frame))
+
+	shard.evictIfOverBudget()
+}
+
+// evictIfOverBudget runs a CLOCK-style second-chance sweep over the FIFO
+// order queue until the shard is back under its byte budget. Called with
+// shard.mu held. File space for evicted entries is reclaimed only when its
+// shard file is eventually superseded, same tradeoff as the in-memory ring.
+func (shard *diskShard) evictIfOverBudget() {
+	for shard.liveBytes > shard.maxBytes && len(shard.order) > 0 {
+		hash := shard.order[0]
+		shard.order = shard.order[1:]
+
+		loc, ok := shard.locations[hash]
+		if !ok {
+			continue // already invalidated
+		}
+		if shard.referenced[hash] {
+			shard.referenced[hash] = false
+			shard.order = append(shard.order, hash)
+			continue
+		}
+
+		delete(shard.locations, hash)
+		delete(shard.referenced, hash)
+		shard.liveBytes -= loc.length
+	}
+}
+
+// Get reads key back from disk, if present.
+func (d *DiskTier) Get(hash uint64, key string) (string, bool) {
+	shard := d.shardFor(hash)
+
+	shard.mu.Lock()
+	loc, ok := shard.locations[hash]
+	var file *os.File
+	var err error
+	if ok {
+		shard.referenced[hash] = true
+		file, err = shard.fileFor(loc.fileIdx)
+	}
+	shard.mu.Unlock()
+
+	if !ok || err != nil {
+		return "", false
+	}
+
+	buf := make([]byte, loc.length)
+	if _, err := file.ReadAt(buf, loc.offset); err != nil {
+		return "", false
+	}
+	_, timestamp, ttl, storedKey, value := decodeFrame(buf)
+	if storedKey != key {
+		return "", false
+	}
+	if expired(timestamp, ttl, d.defaultTTL) {
+		d.Invalidate(hash)
+		return "", false
+	}
+	return value, true
+}
+
+// Invalidate drops key from the disk index, e.g. once it has been promoted
+// back into memory.
+func (d *DiskTier) Invalidate(hash uint64) {
+	shard := d.shardFor(hash)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.locations, hash)
+	delete(shard.referenced, hash)
+}
+
+// Flush blocks until every previously queued Spill has been written.
+func (d *DiskTier) Flush() {
+	for atomic.LoadInt64(&d.queued) > 0 {
+		runtime.Gosched()
+	}
+}
+
+// Stats reports the disk tier's current counters.
+func (d *DiskTier) Stats() DiskStats {
+	var bytesUsed int64
+	for _, shard := range d.shards {
+		shard.mu.Lock()
+		bytesUsed += shard.liveBytes
+		shard.mu.Unlock()
+	}
+	return DiskStats{
+		WriteQueueDepth: len(d.jobs) + int(atomic.LoadInt64(&d.queued)),
+		BytesUsed:       bytesUsed,
+	}
+}
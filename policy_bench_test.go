@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchShardBytes caps each shard well below what the workloads below need
+// to hold their full key set, so eviction actually happens during the
+// benchmark instead of every key fitting comfortably in memory. Without
+// this, LRU and SIEVE report identical hit rates because nothing is ever
+// evicted and the comparison is vacuous.
+const benchShardBytes = 4096
+
+// workload replays against a fresh cache built with the given policy and
+// returns the fraction of Gets that hit.
+type workload func(cache *ShardedCache) (hits, total int)
+
+func hitRate(policy Policy, w workload) float64 {
+	cache, _ := NewShardedCacheWithConfig(Config{Policy: policy, MaxShardBytes: benchShardBytes})
+	hits, total := w(cache)
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// zipfianWorkload simulates a read-heavy load skewed towards a hot subset of
+// keys, with occasional writes to keep the working set resident.
+func zipfianWorkload(keyCount, n int) workload {
+	return func(cache *ShardedCache) (hits, total int) {
+		rng := rand.New(rand.NewSource(1))
+		zipf := rand.NewZipf(rng, 1.5, 1, uint64(keyCount-1))
+		for i := 0; i < n; i++ {
+			k := fmt.Sprintf("key-%d", zipf.Uint64())
+			if i%20 == 0 {
+				cache.Put(k, "v")
+				continue
+			}
+			total++
+			if _, found := cache.Get(k); found {
+				hits++
+			}
+		}
+		return hits, total
+	}
+}
+
+// scanWorkload simulates a one-pass scan over a large cold key range that
+// should not be able to push a much smaller hot set out of the cache.
+func scanWorkload(hotKeys, scanKeys, n int) workload {
+	return func(cache *ShardedCache) (hits, total int) {
+		for i := 0; i < hotKeys; i++ {
+			cache.Put(fmt.Sprintf("hot-%d", i), "v")
+		}
+		for i := 0; i < n; i++ {
+			if i%4 == 0 {
+				total++
+				if _, found := cache.Get(fmt.Sprintf("hot-%d", i%hotKeys)); found {
+					hits++
+				}
+				continue
+			}
+			k := fmt.Sprintf("scan-%d", i%scanKeys)
+			cache.Put(k, "v")
+		}
+		return hits, total
+	}
+}
+
+func benchmarkHitRate(b *testing.B, w workload) {
+	for _, p := range []struct {
+		name   string
+		policy Policy
+	}{{"LRU", PolicyLRU}, {"SIEVE", PolicySIEVE}} {
+		b.Run(p.name, func(b *testing.B) {
+			var rate float64
+			for i := 0; i < b.N; i++ {
+				rate = hitRate(p.policy, w)
+			}
+			b.ReportMetric(rate*100, "hit-%")
+		})
+	}
+}
+
+func BenchmarkHitRateZipfian(b *testing.B) {
+	benchmarkHitRate(b, zipfianWorkload(2000, 20000))
+}
+
+func BenchmarkHitRateScan(b *testing.B) {
+	benchmarkHitRate(b, scanWorkload(100, 20000, 20000))
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// frameHeaderSize is the fixed-size prefix on every entry written into a
+// shard's ring buffer: a total-length uint32, a unix-nano timestamp uint64,
+// a per-entry TTL uint64 (nanoseconds, 0 meaning "use the cache default"),
+// and a key-length uint16. The value bytes follow the key bytes and their
+// length is derived as totalLen - frameHeaderSize - keyLen.
+const frameHeaderSize = 4 + 8 + 8 + 2
+
+const (
+	// initialShardBytes is the starting size of a shard's ring buffer. It
+	// grows (and compacts away dead frames) on demand up to the shard's
+	// maxBytes, which defaults to maxShardBytes unless Config.MaxShardBytes
+	// overrides it (e.g. to force eviction in a test with a small working
+	// set).
+	initialShardBytes = 4 * 1024
+	maxShardBytes     = MaxMemoryBytes / NumShards
+)
+
+// Shard is a single shard of the cache. Entries are packed into a single
+// contiguous []byte ring buffer (the layout BigCache uses) instead of a
+// linked structure, so the GC only ever has to scan one slice header per
+// shard rather than one object per entry.
+type Shard struct {
+	lock sync.RWMutex
+
+	hashmap   map[uint64]uint32 // fnv64a(key) -> offset of the frame start in buf
+	buf       []byte
+	writePos  uint32
+	oldestPos uint32
+	used      uint32
+
+	// visited holds one atomic bit per live key when policy is
+	// PolicySIEVE, so Get can mark a key as reused without ever taking
+	// more than a read lock on the shard.
+	visited map[uint64]*int32
+
+	policy     Policy
+	defaultTTL time.Duration // 0 disables TTL-based expiry by default
+	maxBytes   uint32        // ceiling grow() won't cross; see Config.MaxShardBytes
+
+	// onEvict, if set, is handed every entry evicted from memory (but not
+	// ones found already stale) along with its ttl/timestamp and why, so a
+	// second tier can carry the entry's expiry onto disk and/or a user
+	// callback can observe it.
+	onEvict func(hash uint64, key, value string, ttl time.Duration, timestamp time.Time, reason EvictReason)
+
+	// collisions, if set, counts distinct keys found to share a hash (and
+	// so shadow one another in hashmap) on the read path. It points at a
+	// counter owned by the ShardedCache so all shards share one total.
+	collisions *int64
+}
+
+func newShard(cfg Config) *Shard {
+	maxBytes := uint32(maxShardBytes)
+	if cfg.MaxShardBytes > 0 {
+		maxBytes = uint32(cfg.MaxShardBytes)
+	}
+	initialBytes := uint32(initialShardBytes)
+	if initialBytes > maxBytes {
+		initialBytes = maxBytes
+	}
+	s := &Shard{
+		hashmap:    make(map[uint64]uint32),
+		buf:        make([]byte, initialBytes),
+		policy:     cfg.Policy,
+		defaultTTL: cfg.DefaultTTL,
+		maxBytes:   maxBytes,
+	}
+	if s.policy == PolicySIEVE {
+		s.visited = make(map[uint64]*int32)
+	}
+	return s
+}
+
+func encodeFrame(key, value string, ttl time.Duration) []byte {
+	return encodeFrameAt(time.Now(), key, value, ttl)
+}
+
+// encodeFrameAt is encodeFrame with an explicit timestamp, so requeue can
+// preserve a requeued entry's original write time instead of stamping a
+// fresh one.
+func encodeFrameAt(timestamp time.Time, key, value string, ttl time.Duration) []byte {
+	frame := make([]byte, frameHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(frame)))
+	binary.BigEndian.PutUint64(frame[4:12], uint64(timestamp.UnixNano()))
+	binary.BigEndian.PutUint64(frame[12:20], uint64(ttl))
+	binary.BigEndian.PutUint16(frame[20:22], uint16(len(key)))
+	copy(frame[frameHeaderSize:], key)
+	copy(frame[frameHeaderSize+len(key):], value)
+	return frame
+}
+
+// expired reports whether a frame written at timestamp with per-entry ttl
+// has expired, falling back to defaultTTL when ttl is zero.
+func expired(timestamp time.Time, ttl, defaultTTL time.Duration) bool {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return ttl > 0 && time.Since(timestamp) > ttl
+}
+
+// readAt copies n bytes starting at the circular position pos, wrapping
+// around the end of buf as needed.
+func (s *Shard) readAt(pos uint32, n uint32) []byte {
+	out := make([]byte, n)
+	capacity := uint32(len(s.buf))
+	if pos+n <= capacity {
+		copy(out, s.buf[pos:pos+n])
+		return out
+	}
+	first := capacity - pos
+	copy(out, s.buf[pos:])
+	copy(out[first:], s.buf[:n-first])
+	return out
+}
+
+// writeAt writes data starting at the circular position pos, wrapping
+// around the end of buf as needed.
+func (s *Shard) writeAt(pos uint32, data []byte) {
+	capacity := uint32(len(s.buf))
+	if pos+uint32(len(data)) <= capacity {
+		copy(s.buf[pos:], data)
+		return
+	}
+	first := capacity - pos
+	copy(s.buf[pos:], data[:first])
+	copy(s.buf[:], data[first:])
+}
+
+// decodeFrame parses a linear (non-wrapping) frame previously produced by
+// encodeFrame, such as one read back from a disk file.
+func decodeFrame(data []byte) (totalLen uint32, timestamp time.Time, ttl time.Duration, key, value string) {
+	totalLen = binary.BigEndian.Uint32(data[0:4])
+	timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(data[4:12])))
+	ttl = time.Duration(binary.BigEndian.Uint64(data[12:20]))
+	keyLen := binary.BigEndian.Uint16(data[20:22])
+
+	key = string(data[frameHeaderSize : frameHeaderSize+keyLen])
+	value = string(data[frameHeaderSize+keyLen : totalLen])
+	return
+}
+
+// frameAt decodes the header fields and key/value bytes of the frame
+// starting at pos.
+func (s *Shard) frameAt(pos uint32) (totalLen uint32, timestamp time.Time, ttl time.Duration, key, value string) {
+	totalLen = binary.BigEndian.Uint32(s.readAt(pos, 4))
+	return decodeFrame(s.readAt(pos, totalLen))
+}
+
+// popOldest reclaims space from the tail of the ring according to the
+// shard's eviction policy, and reports how many bytes it freed.
+func (s *Shard) popOldest() int64 {
+	if s.policy == PolicySIEVE {
+		return s.popOldestSIEVE()
+	}
+	return s.popOldestFIFO()
+}
+
+// popOldestFIFO reclaims frames starting at oldestPos, without regard to
+// whether a live one was recently reused. This is PolicyLRU: the
+// bytes-queue rewrite dropped true recency reordering to avoid per-entry
+// allocations, so what remains is strict arrival-order eviction. It walks
+// past any number of stale frames — dead weight left behind by an
+// overwrite, per the net-delta accounting in put — without reporting their
+// length, mirroring popOldestSIEVE's stale-skip, so the caller's running
+// total only ever sees the bytes an actually-live eviction freed.
+func (s *Shard) popOldestFIFO() int64 {
+	for s.used > 0 {
+		totalLen, timestamp, ttl, key, value := s.frameAt(s.oldestPos)
+		hash := hashKey(key)
+
+		if s.hashmap[hash] != s.oldestPos {
+			// Stale frame left behind by an overwrite; just reclaim it.
+			s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+			s.used -= totalLen
+			continue
+		}
+
+		delete(s.hashmap, hash)
+		if s.onEvict != nil {
+			s.onEvict(hash, key, value, ttl, timestamp, ReasonMemoryPressure)
+		}
+		s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+		s.used -= totalLen
+		return int64(totalLen)
+	}
+	return 0
+}
+
+// popOldestSIEVE walks the tail like popOldestFIFO, but gives a visited
+// live entry one second chance: it is requeued at the head with its
+// visited bit cleared instead of being evicted outright. This is a
+// requeue-based approximation of SIEVE's in-place hand, not the textbook
+// algorithm: a true hand leaves the object where it is and just skips over
+// it, but this ring buffer can only ever reclaim space from a contiguous
+// tail, so giving an entry a second chance here means physically moving it
+// to the head instead. The walk is bounded to twice the live entry count,
+// which is enough for every entry to have been requeued at most once
+// before something is actually freed.
+func (s *Shard) popOldestSIEVE() int64 {
+	limit := 2*len(s.hashmap) + 1
+	for i := 0; i < limit && s.used > 0; i++ {
+		totalLen, timestamp, ttl, key, value := s.frameAt(s.oldestPos)
+		hash := hashKey(key)
+
+		if s.hashmap[hash] != s.oldestPos {
+			// Stale frame left behind by an overwrite; just reclaim it.
+			s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+			s.used -= totalLen
+			continue
+		}
+
+		if v := s.visited[hash]; v != nil && atomic.LoadInt32(v) != 0 {
+			atomic.StoreInt32(v, 0)
+			s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+			s.used -= totalLen
+			s.requeue(hash, key, value, ttl, timestamp)
+			continue
+		}
+
+		delete(s.hashmap, hash)
+		delete(s.visited, hash)
+		if s.onEvict != nil {
+			s.onEvict(hash, key, value, ttl, timestamp, ReasonMemoryPressure)
+		}
+		s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+		s.used -= totalLen
+		return int64(totalLen)
+	}
+	return 0
+}
+
+// requeue re-writes key/value at the current write position, giving it a
+// fresh position at the head of the ring, but keeps its original timestamp
+// so a second chance doesn't also reset its TTL countdown or break
+// reapExpired's assumption that the tail stays in roughly arrival order.
+// Space for it was just freed by the caller, so this never needs to evict
+// further.
+func (s *Shard) requeue(hash uint64, key, value string, ttl time.Duration, timestamp time.Time) {
+	frame := encodeFrameAt(timestamp, key, value, ttl)
+	offset := s.writePos
+	s.writeAt(offset, frame)
+	s.writePos = (offset + uint32(len(frame))) % uint32(len(s.buf))
+	s.used += uint32(len(frame))
+	s.hashmap[hash] = offset
+	s.visited[hash] = new(int32)
+}
+
+// grow doubles the ring buffer (up to s.maxBytes) and, while relocating
+// the live frames into it, drops any stale frames left behind by
+// overwritten keys. Returns false if the buffer is already at its ceiling.
+func (s *Shard) grow() bool {
+	capacity := uint32(len(s.buf))
+	if capacity >= s.maxBytes {
+		return false
+	}
+
+	newCapacity := capacity * 2
+	if newCapacity > s.maxBytes {
+		newCapacity = s.maxBytes
+	}
+	newBuf := make([]byte, newCapacity)
+	newHashmap := make(map[uint64]uint32, len(s.hashmap))
+	var newVisited map[uint64]*int32
+	if s.policy == PolicySIEVE {
+		newVisited = make(map[uint64]*int32, len(s.visited))
+	}
+
+	var writePos uint32
+	for pos, remaining := s.oldestPos, s.used; remaining > 0; {
+		totalLen, _, _, key, _ := s.frameAt(pos)
+		hash := hashKey(key)
+		if s.hashmap[hash] == pos {
+			frame := s.readAt(pos, totalLen)
+			copy(newBuf[writePos:], frame)
+			newHashmap[hash] = writePos
+			if s.policy == PolicySIEVE {
+				newVisited[hash] = s.visited[hash]
+			}
+			writePos += totalLen
+		}
+		pos = (pos + totalLen) % uint32(len(s.buf))
+		remaining -= totalLen
+	}
+
+	s.buf = newBuf
+	s.hashmap = newHashmap
+	s.visited = newVisited
+	s.oldestPos = 0
+	s.writePos = writePos
+	s.used = writePos
+	return true
+}
+
+// ensureSpace makes room for n more bytes by growing the buffer and, once
+// it is at its ceiling, evicting from the tail.
+func (s *Shard) ensureSpace(n uint32) {
+	for uint32(len(s.buf))-s.used < n {
+		if s.grow() {
+			continue
+		}
+		if s.popOldest() == 0 {
+			return // buffer empty and n still doesn't fit; caller's entry is larger than s.maxBytes
+		}
+	}
+}
+
+// put writes key/value as a new frame with the given per-entry ttl (0 to
+// fall back to the cache's DefaultTTL), appending rather than updating in
+// place; any previous frame for this key is left as dead weight that is
+// reclaimed the next time the tail (or a grow compaction) reaches it. If
+// the key was already live, onEvict fires with ReasonReplaced first.
+//
+// The return value is the net change in live bytes, not the frame's raw
+// length: overwriting a live key returns newLen-oldLen (the old frame's
+// bytes are already spoken for by this delta, even though they physically
+// stay in the ring as dead weight until something reclaims them), so the
+// caller's running total tracks live bytes rather than growing unbounded
+// on repeated overwrites of the same key.
+func (s *Shard) put(hash uint64, key, value string, ttl time.Duration) int64 {
+	var oldLen int64
+	if oldOffset, found := s.hashmap[hash]; found {
+		if oldTotalLen, oldTimestamp, oldTTL, oldKey, oldValue := s.frameAt(oldOffset); oldKey == key {
+			oldLen = int64(oldTotalLen)
+			if s.onEvict != nil {
+				s.onEvict(hash, oldKey, oldValue, oldTTL, oldTimestamp, ReasonReplaced)
+			}
+		}
+	}
+
+	frame := encodeFrame(key, value, ttl)
+	s.ensureSpace(uint32(len(frame)))
+
+	offset := s.writePos
+	s.writeAt(offset, frame)
+	s.writePos = (offset + uint32(len(frame))) % uint32(len(s.buf))
+	s.used += uint32(len(frame))
+	s.hashmap[hash] = offset
+	if s.policy == PolicySIEVE {
+		s.visited[hash] = new(int32)
+	}
+
+	return int64(len(frame)) - oldLen
+}
+
+// get looks up hash/key, verifying the stored key to guard against hash
+// collisions, and enforces the entry's TTL (or the shard's DefaultTTL).
+func (s *Shard) get(hash uint64, key string) (string, bool) {
+	offset, found := s.hashmap[hash]
+	if !found {
+		return "", false
+	}
+
+	_, timestamp, ttl, storedKey, value := s.frameAt(offset)
+	if storedKey != key {
+		if s.collisions != nil {
+			atomic.AddInt64(s.collisions, 1)
+		}
+		return "", false
+	}
+	if expired(timestamp, ttl, s.defaultTTL) {
+		return "", false
+	}
+	if s.policy == PolicySIEVE {
+		if v := s.visited[hash]; v != nil {
+			atomic.StoreInt32(v, 1)
+		}
+	}
+	return value, true
+}
+
+// reapExpired walks from the tail, reclaiming entries whose TTL has
+// elapsed and stopping at the first one that hasn't. This keeps cleanup
+// O(expired) rather than O(n) when the shard's entries share a roughly
+// uniform TTL and therefore stay close to insertion order; entries with a
+// much shorter per-key TTL than their neighbours may linger until the tail
+// reaches them.
+func (s *Shard) reapExpired() int64 {
+	var freed int64
+	for s.used > 0 {
+		totalLen, timestamp, ttl, key, value := s.frameAt(s.oldestPos)
+		hash := hashKey(key)
+
+		if s.hashmap[hash] != s.oldestPos {
+			// Stale frame left behind by an overwrite; reclaim and keep going.
+			s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+			s.used -= totalLen
+			continue
+		}
+
+		if !expired(timestamp, ttl, s.defaultTTL) {
+			return freed
+		}
+
+		delete(s.hashmap, hash)
+		delete(s.visited, hash)
+		if s.onEvict != nil {
+			s.onEvict(hash, key, value, ttl, timestamp, ReasonExpired)
+		}
+		s.oldestPos = (s.oldestPos + totalLen) % uint32(len(s.buf))
+		s.used -= totalLen
+		freed += int64(totalLen)
+	}
+	return freed
+}
+
+// delete removes hash/key from the live index, reporting the length of the
+// frame reclaimed (as dead weight, same as an overwrite) and whether the
+// key was found. OnEvict fires with ReasonManualDelete.
+func (s *Shard) delete(hash uint64, key string) (int64, bool) {
+	offset, found := s.hashmap[hash]
+	if !found {
+		return 0, false
+	}
+
+	totalLen, timestamp, ttl, storedKey, value := s.frameAt(offset)
+	if storedKey != key {
+		return 0, false
+	}
+
+	delete(s.hashmap, hash)
+	delete(s.visited, hash)
+	if s.onEvict != nil {
+		s.onEvict(hash, key, value, ttl, timestamp, ReasonManualDelete)
+	}
+	return int64(totalLen), true
+}